@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// isTimeoutErr reports whether err is a timeout -- a plain net.Error
+// timeout from the HTTP path, a context deadline from doSingleGet/grpcGet's
+// per-attempt ctx, or the gRPC status it gets wrapped in when the deadline
+// fires mid-call.
+func isTimeoutErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	return status.Code(err) == codes.DeadlineExceeded
+}
+
+// breakerState is the /debug/vars-friendly snapshot of a single backend's
+// breaker.
+type breakerState struct {
+	State         string  `json:"state"`
+	ErrorRate     float64 `json:"errorRate"`
+	ConsecutiveTO int     `json:"consecutiveTimeouts"`
+}
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breakerStates returns a per-backend snapshot for publishing via expvar.
+func breakerStates() interface{} {
+	breakers.mu.RLock()
+	defer breakers.mu.RUnlock()
+
+	out := make(map[string]breakerState, len(breakers.m))
+	for server, b := range breakers.m {
+		b.mu.Lock()
+		out[server] = breakerState{
+			State:         b.state.String(),
+			ErrorRate:     b.ewmaErrorRate,
+			ConsecutiveTO: b.consecutiveTimeout,
+		}
+		b.mu.Unlock()
+	}
+
+	return out
+}
+
+// circuit states, closed -> open -> half-open -> closed
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// breaker tracks the health of a single backend using an EWMA error rate,
+// plus a run of consecutive timeouts.  While open, callers are expected to
+// skip the backend entirely rather than let it soak up the timeout budget.
+type breaker struct {
+	mu sync.Mutex
+
+	state      circuitState
+	openedAt   time.Time
+	halfProbes int
+
+	// ewmaErrorRate decays exponentially with Config.CircuitBreaker.WindowMs
+	// as its time constant, so a backend that's been erroring steadily
+	// stays visible instead of the rate artificially dropping to zero the
+	// instant a tumbling window would have reset -- that reset is exactly
+	// the boundary artifact a true EWMA is meant to avoid.
+	ewmaErrorRate      float64
+	lastSample         time.Time
+	samples            int
+	consecutiveTimeout int
+}
+
+func newBreaker() *breaker {
+	return &breaker{}
+}
+
+// allow reports whether a request to this backend should be attempted, and
+// whether it's a half-open probe (so the caller can count it separately).
+func (b *breaker) allow() (ok bool, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitClosed:
+		return true, false
+	case circuitOpen:
+		if time.Since(b.openedAt) < time.Duration(Config.CircuitBreaker.OpenMs)*time.Millisecond {
+			return false, false
+		}
+		b.state = circuitHalfOpen
+		b.halfProbes = 0
+		fallthrough
+	case circuitHalfOpen:
+		if b.halfProbes >= Config.CircuitBreaker.HalfOpenProbes {
+			return false, false
+		}
+		b.halfProbes++
+		return true, true
+	}
+
+	return true, false
+}
+
+// record updates the breaker with the outcome of a request that allow()
+// admitted.
+func (b *breaker) record(server string, success bool, timeout bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitHalfOpen {
+		if success {
+			b.state = circuitClosed
+			b.ewmaErrorRate = 0
+			b.samples = 0
+			b.consecutiveTimeout = 0
+		} else {
+			b.trip(server)
+		}
+		return
+	}
+
+	if timeout {
+		b.consecutiveTimeout++
+	} else {
+		b.consecutiveTimeout = 0
+	}
+
+	b.samples++
+
+	sample := 0.0
+	if !success {
+		sample = 1.0
+	}
+
+	now := time.Now()
+	tau := time.Duration(Config.CircuitBreaker.WindowMs) * time.Millisecond
+	decay := math.Exp(-now.Sub(b.lastSample).Seconds() / tau.Seconds())
+	b.ewmaErrorRate = b.ewmaErrorRate*decay + sample*(1-decay)
+	b.lastSample = now
+
+	consecutiveTrip := Config.CircuitBreaker.ConsecutiveTimeouts > 0 &&
+		b.consecutiveTimeout >= Config.CircuitBreaker.ConsecutiveTimeouts
+
+	if b.samples >= circuitMinSamples && (b.ewmaErrorRate >= Config.CircuitBreaker.ErrorThreshold || consecutiveTrip) {
+		b.trip(server)
+	}
+}
+
+// circuitMinSamples keeps a single unlucky request from tripping the
+// breaker before we've seen enough traffic to trust the error rate.
+const circuitMinSamples = 5
+
+// trip must be called with b.mu held.
+func (b *breaker) trip(server string) {
+	if b.state == circuitOpen {
+		return
+	}
+	b.state = circuitOpen
+	b.openedAt = time.Now()
+	Metrics.CircuitOpen.Add(1)
+	baseLogger.Warn().Str("backend", server).Msg("circuit breaker opening")
+}
+
+// breakers holds one breaker per configured backend.
+var breakers = struct {
+	mu sync.RWMutex
+	m  map[string]*breaker
+}{m: make(map[string]*breaker)}
+
+func breakerFor(server string) *breaker {
+	breakers.mu.RLock()
+	b, ok := breakers.m[server]
+	breakers.mu.RUnlock()
+	if ok {
+		return b
+	}
+
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+	if b, ok = breakers.m[server]; ok {
+		return b
+	}
+	b = newBreaker()
+	breakers.m[server] = b
+	return b
+}
+
+// backoff returns a truncated exponential backoff delay with jitter, using
+// the same formula gRPC uses for connection backoff: delay = min(maxMs,
+// baseMs * factor^attempt), then randomized within +/- jitter fraction.
+func backoff(attempt int) time.Duration {
+	r := Config.Retry
+	delay := float64(r.BaseMs)
+	for i := 0; i < attempt; i++ {
+		delay *= r.Factor
+		if delay > float64(r.MaxMs) {
+			delay = float64(r.MaxMs)
+			break
+		}
+	}
+
+	jitter := delay * r.Jitter
+	delay = delay - jitter + rand.Float64()*2*jitter
+
+	return time.Duration(delay) * time.Millisecond
+}
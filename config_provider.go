@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// configuredConcurrencyLimit is the -limit flag value; reloadBackends needs
+// it to rebuild Limiter with the same per-backend concurrency cap whenever
+// the backend list changes.
+var configuredConcurrencyLimit int
+
+// Backend is the shape []Backend-returning discovery sources answer with;
+// Config.Backends itself stays a flat []string since that's what
+// multiGet/singleGet already key everything off of.
+type Backend struct {
+	Address string `json:"address"`
+}
+
+// ConfigProvider supplies the live backend list and pushes updates to
+// onChange as it learns about them, so main can hot-swap Config.Backends
+// without a restart and without dropping in-flight requests.
+type ConfigProvider interface {
+	// Backends returns the current backend list.
+	Backends() ([]string, error)
+
+	// Watch blocks pushing updates to onChange as they're observed, until
+	// stop is closed. Providers with nothing to watch (a file read once)
+	// may return immediately.
+	Watch(stop <-chan struct{}, onChange func([]string))
+}
+
+// fileConfigProvider re-reads Config.Backends from the original JSON config
+// file. It doesn't watch -- reloading it is driven by SIGHUP (see main).
+type fileConfigProvider struct {
+	path string
+}
+
+func (f *fileConfigProvider) Backends() ([]string, error) {
+	cfgjs, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfgjs = stripCommentHeader(cfgjs)
+	if cfgjs == nil {
+		return nil, fmt.Errorf("error removing header comment from %s", f.path)
+	}
+
+	var cfg struct{ Backends []string }
+	if err := json.Unmarshal(cfgjs, &cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg.Backends, nil
+}
+
+func (f *fileConfigProvider) Watch(stop <-chan struct{}, onChange func([]string)) {}
+
+// dirConfigProvider watches a directory for a backends.json (`[]Backend`)
+// dropped in by a deploy tool, and pushes whenever it changes.
+type dirConfigProvider struct {
+	dir string
+}
+
+func (d *dirConfigProvider) backendsFile() string {
+	return filepath.Join(d.dir, "backends.json")
+}
+
+func (d *dirConfigProvider) Backends() ([]string, error) {
+	buf, err := ioutil.ReadFile(d.backendsFile())
+	if err != nil {
+		return nil, err
+	}
+
+	var backends []Backend
+	if err := json.Unmarshal(buf, &backends); err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, len(backends))
+	for i, b := range backends {
+		addrs[i] = b.Address
+	}
+	return addrs, nil
+}
+
+func (d *dirConfigProvider) Watch(stop <-chan struct{}, onChange func([]string)) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		baseLogger.Error().Err(err).Str("dir", d.dir).Msg("can't watch config directory")
+		return
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(d.dir); err != nil {
+		baseLogger.Error().Err(err).Str("dir", d.dir).Msg("can't watch config directory")
+		return
+	}
+
+	for {
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if ev.Name != d.backendsFile() {
+				continue
+			}
+			if ev.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+
+			backends, err := d.Backends()
+			if err != nil {
+				baseLogger.Error().Err(err).Msg("error re-reading backends.json")
+				continue
+			}
+			onChange(backends)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			baseLogger.Error().Err(err).Msg("fsnotify error watching config directory")
+
+		case <-stop:
+			return
+		}
+	}
+}
+
+// discoverySource resolves the current backend set from somewhere other
+// than a local file -- DNS SRV records, or a small HTTP registry.
+type discoverySource interface {
+	Lookup() ([]string, error)
+}
+
+// dnsSRVSource resolves a SRV record into http://host:port backends.
+type dnsSRVSource struct {
+	service, proto, name string
+}
+
+func (d *dnsSRVSource) Lookup() ([]string, error) {
+	_, srvs, err := net.LookupSRV(d.service, d.proto, d.name)
+	if err != nil {
+		return nil, err
+	}
+
+	backends := make([]string, len(srvs))
+	for i, s := range srvs {
+		backends[i] = fmt.Sprintf("http://%s:%d", trimTrailingDot(s.Target), s.Port)
+	}
+	sort.Strings(backends)
+	return backends, nil
+}
+
+func trimTrailingDot(s string) string {
+	if len(s) > 0 && s[len(s)-1] == '.' {
+		return s[:len(s)-1]
+	}
+	return s
+}
+
+// httpRegistrySource polls an HTTP endpoint that answers with `[]Backend`.
+type httpRegistrySource struct {
+	url    string
+	client *http.Client
+}
+
+func (h *httpRegistrySource) Lookup() ([]string, error) {
+	client := h.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Get(h.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var backends []Backend
+	if err := json.NewDecoder(resp.Body).Decode(&backends); err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, len(backends))
+	for i, b := range backends {
+		addrs[i] = b.Address
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+// discoveryConfigProvider polls a discoverySource on an interval and pushes
+// onChange only when the resolved backend set actually differs.
+type discoveryConfigProvider struct {
+	source   discoverySource
+	interval time.Duration
+}
+
+func (p *discoveryConfigProvider) Backends() ([]string, error) {
+	return p.source.Lookup()
+}
+
+func (p *discoveryConfigProvider) Watch(stop <-chan struct{}, onChange func([]string)) {
+	if p.interval <= 0 {
+		p.interval = 30 * time.Second
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	var last []string
+	for {
+		select {
+		case <-ticker.C:
+			backends, err := p.source.Lookup()
+			if err != nil {
+				baseLogger.Error().Err(err).Msg("discovery lookup failed")
+				continue
+			}
+			if !stringsEqual(backends, last) {
+				last = backends
+				onChange(backends)
+			}
+
+		case <-stop:
+			return
+		}
+	}
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// newConfigProvider picks a ConfigProvider for Config.Discovery.Mode. The
+// file provider is always the fallback, since SIGHUP has to go somewhere
+// even when no dynamic discovery is configured.
+func newConfigProvider(configFile string) ConfigProvider {
+	switch Config.Discovery.Mode {
+	case "dir":
+		return &dirConfigProvider{dir: Config.Discovery.Dir}
+	case "dns":
+		return &discoveryConfigProvider{
+			source: &dnsSRVSource{
+				service: Config.Discovery.DNSService,
+				proto:   Config.Discovery.DNSProto,
+				name:    Config.Discovery.DNSName,
+			},
+			interval: time.Duration(Config.Discovery.PollMs) * time.Millisecond,
+		}
+	case "http":
+		return &discoveryConfigProvider{
+			source:   &httpRegistrySource{url: Config.Discovery.RegistryURL},
+			interval: time.Duration(Config.Discovery.PollMs) * time.Millisecond,
+		}
+	default:
+		return &fileConfigProvider{path: configFile}
+	}
+}
+
+// reloadBackends atomically swaps in a new backend list and rebuilds
+// everything keyed by backend address -- the limiter, the circuit
+// breakers, and pooled gRPC connections -- without touching requests
+// already in flight (they hold their own reference to the old Limiter
+// map and finish against it normally).
+func reloadBackends(newBackends []string) {
+	if len(newBackends) == 0 {
+		baseLogger.Warn().Msg("config reload produced zero backends, ignoring")
+		return
+	}
+
+	newSet := make(map[string]bool, len(newBackends))
+	for _, b := range newBackends {
+		newSet[b] = true
+	}
+
+	Config.mu.Lock()
+	removed := make(map[string]bool)
+	for _, b := range Config.Backends {
+		if !newSet[b] {
+			removed[b] = true
+		}
+	}
+	Config.Backends = newBackends
+
+	for metric, servers := range Config.metricPaths {
+		// Build a fresh slice rather than compacting in place: a request
+		// that read this slice before we took the lock may still be
+		// reading it unlocked (see renderHandler), and reusing servers'
+		// backing array would corrupt it out from under that reader.
+		var kept []string
+		for _, s := range servers {
+			if !removed[s] {
+				kept = append(kept, s)
+			}
+		}
+		if len(kept) == 0 {
+			delete(Config.metricPaths, metric)
+		} else {
+			Config.metricPaths[metric] = kept
+		}
+	}
+	Config.mu.Unlock()
+
+	if configuredConcurrencyLimit != 0 {
+		setLimiter(newServerLimiter(newBackends, configuredConcurrencyLimit))
+	}
+
+	breakers.mu.Lock()
+	for b := range removed {
+		delete(breakers.m, b)
+	}
+	breakers.mu.Unlock()
+
+	grpcConns.mu.Lock()
+	for b := range removed {
+		if cc, ok := grpcConns.m[b]; ok {
+			cc.Close()
+			delete(grpcConns.m, b)
+		}
+	}
+	grpcConns.mu.Unlock()
+
+	baseLogger.Info().Strs("backends", newBackends).Int("removed", len(removed)).Msg("reloaded backend config")
+}
+
+// startConfigReload wires up the configured ConfigProvider: SIGHUP always
+// forces a reload, and providers that can push changes (dir, dns, http) get
+// their own watch goroutine.
+func startConfigReload(configFile string) {
+	provider := newConfigProvider(configFile)
+
+	stop := make(chan struct{})
+	go provider.Watch(stop, reloadBackends)
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			backends, err := provider.Backends()
+			if err != nil {
+				baseLogger.Error().Err(err).Msg("SIGHUP reload failed")
+				continue
+			}
+			reloadBackends(backends)
+		}
+	}()
+}
+
+// configHandler exposes the live, reloadable part of Config -- separate
+// from /debug/vars's full dump, which also includes internal bookkeeping
+// fields that aren't meant for machine consumption.
+func configHandler(w http.ResponseWriter, req *http.Request) {
+	Config.mu.RLock()
+	resp := struct {
+		Backends  []string    `json:"backends"`
+		Discovery interface{} `json:"discovery"`
+	}{
+		Backends:  Config.Backends,
+		Discovery: Config.Discovery,
+	}
+	Config.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
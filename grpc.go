@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/gogoprotobuf/proto"
+	"google.golang.org/grpc"
+
+	pb "github.com/dgryski/carbonzipper/carbonzipperpb"
+)
+
+// isGRPCBackend reports whether a Config.Backends entry should be dialed
+// over gRPC rather than HTTP+protobuf, per its scheme.
+func isGRPCBackend(server string) bool {
+	return strings.HasPrefix(server, "grpc://")
+}
+
+// grpcConns pools one ClientConn per backend; HTTP/2 multiplexes calls over
+// it, so unlike storageClient there's no need for a per-host idle pool.
+var grpcConns = struct {
+	mu sync.Mutex
+	m  map[string]*grpc.ClientConn
+}{m: make(map[string]*grpc.ClientConn)}
+
+func grpcConnFor(server string) (*grpc.ClientConn, error) {
+	target := strings.TrimPrefix(server, "grpc://")
+
+	grpcConns.mu.Lock()
+	defer grpcConns.mu.Unlock()
+
+	if cc, ok := grpcConns.m[server]; ok {
+		return cc, nil
+	}
+
+	cc, err := grpc.Dial(target, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	grpcConns.m[server] = cc
+	return cc, nil
+}
+
+// grpcGet is the gRPC counterpart of doSingleGet: it dials (or reuses) a
+// connection to server, issues whichever of Find/Render uri describes, and
+// re-marshals the answer as protobuf bytes so it can flow through the same
+// findHandlerPB/handleRenderPB decode path HTTP responses already use.
+func grpcGet(uri, server string) (body []byte, err error, retryable bool) {
+	cc, err := grpcConnFor(server)
+	if err != nil {
+		return nil, err, true
+	}
+	client := pb.NewCarbonZipperClient(cc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(Config.TimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	// uri is already just the path+query singleGet built (e.g.
+	// "/metrics/find/?format=protobuf&query=..."), so parse it directly --
+	// prepending server would still carry server's "grpc://" scheme, which
+	// url.Parse reads as "grpc:" and everything after it as path, making
+	// the prefix checks below never match.
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err, false
+	}
+	q := u.Query()
+
+	switch {
+	case strings.HasPrefix(u.Path, "/metrics/find/"):
+		return grpcFind(ctx, client, q.Get("query"))
+	case strings.HasPrefix(u.Path, "/render/"):
+		return grpcRender(ctx, client, q)
+	default:
+		return nil, fmt.Errorf("grpcGet: unrecognized uri %s", uri), false
+	}
+}
+
+func grpcFind(ctx context.Context, client pb.CarbonZipperClient, query string) (body []byte, err error, retryable bool) {
+	resp, err := client.Find(ctx, &pb.GlobRequest{Query: query})
+	if err != nil {
+		return nil, err, true
+	}
+
+	body, err = proto.Marshal(resp)
+	if err != nil {
+		return nil, err, false
+	}
+	return body, nil, false
+}
+
+func grpcRender(ctx context.Context, client pb.CarbonZipperClient, q url.Values) (body []byte, err error, retryable bool) {
+	start, _ := strconv.Atoi(q.Get("from"))
+	stop, _ := strconv.Atoi(q.Get("until"))
+
+	stream, err := client.Render(ctx, &pb.FetchRequest{
+		Path:      q.Get("target"),
+		StartTime: int32(start),
+		StopTime:  int32(stop),
+	})
+	if err != nil {
+		return nil, err, true
+	}
+
+	var metric *pb.FetchResponse
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err, true
+		}
+
+		if metric == nil {
+			metric = chunk
+			continue
+		}
+
+		// fill in whatever this chunk resolved that earlier chunks left
+		// absent, the same way mergeValues reconciles full responses
+		for i := range metric.Values {
+			if metric.IsAbsent[i] && i < len(chunk.Values) && !chunk.IsAbsent[i] {
+				metric.Values[i] = chunk.Values[i]
+				metric.IsAbsent[i] = false
+			}
+		}
+	}
+
+	if metric == nil {
+		return nil, fmt.Errorf("grpcRender: empty stream"), false
+	}
+
+	body, err = proto.Marshal(metric)
+	if err != nil {
+		return nil, err, false
+	}
+	return body, nil, false
+}
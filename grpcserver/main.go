@@ -0,0 +1,90 @@
+// Command grpcserver fronts a carbonserver that only speaks HTTP+protobuf
+// with the CarbonZipper gRPC service, so it can be addressed as a
+// `grpc://` backend before carbonserver grows native gRPC support.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+
+	"code.google.com/p/gogoprotobuf/proto"
+	"google.golang.org/grpc"
+
+	pb "github.com/dgryski/carbonzipper/carbonzipperpb"
+)
+
+type server struct {
+	backend string
+	client  *http.Client
+}
+
+func (s *server) Find(ctx context.Context, req *pb.GlobRequest) (*pb.GlobResponse, error) {
+	uri := fmt.Sprintf("%s/metrics/find/?format=protobuf&query=%s", s.backend, url.QueryEscape(req.Query))
+
+	resp, err := s.client.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var out pb.GlobResponse
+	if err := proto.Unmarshal(body, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+func (s *server) Render(req *pb.FetchRequest, stream pb.CarbonZipper_RenderServer) error {
+	uri := fmt.Sprintf("%s/render/?format=protobuf&target=%s&from=%d&until=%d",
+		s.backend, url.QueryEscape(req.Path), req.StartTime, req.StopTime)
+
+	resp, err := s.client.Get(uri)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var out pb.FetchResponse
+	if err := proto.Unmarshal(body, &out); err != nil {
+		return err
+	}
+
+	// the wrapped backend only ever has the whole response at once, so
+	// there's just one chunk to stream -- a native carbonserver could
+	// emit one message per block as it reads them off disk.
+	return stream.Send(&out)
+}
+
+func main() {
+	listen := flag.String("l", ":8081", "address to listen on for gRPC")
+	backend := flag.String("backend", "http://localhost:8080", "HTTP carbonserver to wrap")
+	flag.Parse()
+
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal("failed to listen: ", err)
+	}
+
+	s := grpc.NewServer()
+	pb.RegisterCarbonZipperServer(s, &server{backend: *backend, client: &http.Client{}})
+
+	log.Println("grpcserver listening on", *listen, "wrapping", *backend)
+	log.Fatal(s.Serve(lis))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"log/syslog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// baseLogger is the process-wide structured sink; findHandler/renderHandler
+// derive a request-scoped child logger from it (see requestLogger) so a
+// single fan-out shows up as one parent event with N child events sharing a
+// correlation id, instead of N unrelated lines.
+var baseLogger zerolog.Logger
+
+// initLogging wires zerolog up to the configured sinks. Both stdout and
+// syslog can be enabled at once, same as the logger it replaces. Stdout
+// emits one JSON object per event like every other sink, unless pretty asks
+// for zerolog's colorized ConsoleWriter instead -- that's for a developer
+// watching a terminal, not for whatever's tailing/shipping the log.
+func initLogging(stdout, useSyslog, pretty bool, level zerolog.Level) error {
+	// latency_ms fields should read as milliseconds, not zerolog's default seconds
+	zerolog.DurationFieldUnit = time.Millisecond
+
+	var writers []io.Writer
+
+	if stdout {
+		if pretty {
+			writers = append(writers, zerolog.ConsoleWriter{Out: os.Stdout})
+		} else {
+			writers = append(writers, os.Stdout)
+		}
+	}
+
+	if useSyslog {
+		w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "carbonzipper")
+		if err != nil {
+			return err
+		}
+		// *syslog.Writer already satisfies io.Writer at LOG_INFO
+		writers = append(writers, w)
+	}
+
+	if len(writers) == 0 {
+		writers = append(writers, os.Stdout)
+	}
+
+	baseLogger = zerolog.New(io.MultiWriter(writers...)).
+		With().
+		Timestamp().
+		Str("component", "carbonzipper").
+		Logger()
+
+	zerolog.SetGlobalLevel(level)
+
+	return nil
+}
+
+// requestLogger returns a child of baseLogger carrying a correlation id for
+// this request -- propagated from X-Request-ID if the caller set one,
+// generated otherwise -- plus which handler is serving it. Every event
+// logged while handling the request, including the ones emitted deeper in
+// multiGet/singleGet, should go through this logger so they can be
+// correlated after the fact.
+func requestLogger(req *http.Request, handler string) zerolog.Logger {
+	reqID := req.Header.Get("X-Request-ID")
+	if reqID == "" {
+		reqID = uuid.New().String()
+	}
+
+	return baseLogger.With().
+		Str("handler", handler).
+		Str("req_id", reqID).
+		Logger()
+}
+
+// logLevelHandler lets operators flip verbosity without a restart:
+// PUT /debug/loglevel?level=debug
+func logLevelHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut {
+		http.Error(w, "PUT only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	level := strings.TrimSpace(req.FormValue("level"))
+	parsed, err := zerolog.ParseLevel(level)
+	if err != nil {
+		http.Error(w, "unknown level "+level, http.StatusBadRequest)
+		return
+	}
+
+	zerolog.SetGlobalLevel(parsed)
+	baseLogger.Info().Str("level", parsed.String()).Msg("log level changed")
+	w.Write([]byte("log level set to " + parsed.String() + "\n"))
+}
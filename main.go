@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"expvar"
@@ -9,7 +10,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
-	"log/syslog"
 	"net/http"
 	_ "net/http/pprof"
 	"net/url"
@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"code.google.com/p/gogoprotobuf/proto"
+	"github.com/rs/zerolog"
 
 	pb "github.com/dgryski/carbonzipper/carbonzipperpb"
 	"github.com/dgryski/httputil"
@@ -45,6 +46,47 @@ var Config = struct {
 	metricPaths map[string][]string
 
 	MaxIdleConnsPerHost int
+
+	CircuitBreaker struct {
+		ErrorThreshold      float64
+		WindowMs            int
+		OpenMs              int
+		HalfOpenProbes      int
+		ConsecutiveTimeouts int
+	}
+
+	Retry struct {
+		Max    int
+		BaseMs int
+		MaxMs  int
+		Factor float64
+		Jitter float64
+	}
+
+	Discovery struct {
+		Mode string // "", "dir", "dns", "http" -- "" means the static file only
+
+		Dir string
+
+		DNSService string
+		DNSProto   string
+		DNSName    string
+
+		RegistryURL string
+
+		PollMs int
+	}
+
+	// Sharding describes how the keyspace is split across backend shard
+	// groups, following the "-no N -of M" convention used by simd: every
+	// metric hashes into exactly one of TotalMachines shards, and each
+	// shard is served by ReplicationFactor backends for redundancy.
+	Sharding struct {
+		TotalMachines     int
+		ReplicationFactor int
+
+		Groups []ShardGroup
+	}
 }{
 	MaxProcs: 1,
 	Port:     8080,
@@ -55,6 +97,44 @@ var Config = struct {
 
 	MaxIdleConnsPerHost: 100,
 
+	CircuitBreaker: struct {
+		ErrorThreshold      float64
+		WindowMs            int
+		OpenMs              int
+		HalfOpenProbes      int
+		ConsecutiveTimeouts int
+	}{
+		ErrorThreshold:      0.5,
+		WindowMs:            10000,
+		OpenMs:              5000,
+		HalfOpenProbes:      3,
+		ConsecutiveTimeouts: 3,
+	},
+
+	Retry: struct {
+		Max    int
+		BaseMs int
+		MaxMs  int
+		Factor float64
+		Jitter float64
+	}{
+		Max:    2,
+		BaseMs: 50,
+		MaxMs:  1000,
+		Factor: 1.6,
+		Jitter: 0.2,
+	},
+
+	Sharding: struct {
+		TotalMachines     int
+		ReplicationFactor int
+
+		Groups []ShardGroup
+	}{
+		TotalMachines:     0, // unset: shardCount falls back to len(Groups)
+		ReplicationFactor: 1,
+	},
+
 	metricPaths: make(map[string][]string),
 }
 
@@ -66,7 +146,8 @@ var Metrics = struct {
 	RenderRequests *expvar.Int
 	RenderErrors   *expvar.Int
 
-	Timeouts *expvar.Int
+	Timeouts    *expvar.Int
+	CircuitOpen *expvar.Int
 }{
 	FindRequests: expvar.NewInt("find_requests"),
 	FindErrors:   expvar.NewInt("find_errors"),
@@ -74,77 +155,157 @@ var Metrics = struct {
 	RenderRequests: expvar.NewInt("render_requests"),
 	RenderErrors:   expvar.NewInt("render_errors"),
 
-	Timeouts: expvar.NewInt("timeouts"),
+	Timeouts:    expvar.NewInt("timeouts"),
+	CircuitOpen: expvar.NewInt("circuit_open"),
 }
 
 var BuildVersion = "(development version)"
 
-var Limiter serverLimiter
+// limiterValue holds the active serverLimiter. reloadBackends swaps it in
+// wholesale when the backend list changes, so it's stored via atomic.Value
+// rather than a bare var: singleGet reads it on every request, concurrently
+// with the reload rebuilding it from scratch.
+var limiterValue atomic.Value
 
-var logger multilog
+func currentLimiter() serverLimiter {
+	sl, _ := limiterValue.Load().(serverLimiter)
+	return sl
+}
+
+func setLimiter(sl serverLimiter) {
+	limiterValue.Store(sl)
+}
 
 type serverResponse struct {
 	server   string
 	response []byte
+	duration time.Duration
 }
 
 var storageClient = &http.Client{}
 
-func singleGet(uri, server string, ch chan<- serverResponse) {
-
+// doSingleGet makes one HTTP attempt against server and classifies the
+// outcome: err is nil for both a genuine 200 and a 404 (carbonserver just
+// doesn't have the metric -- not a failure); retryable tells the caller
+// whether it's worth backing off and trying again.
+func doSingleGet(log zerolog.Logger, uri, server string) (body []byte, err error, retryable bool) {
 	u, err := url.Parse(server + uri)
 	if err != nil {
-		logger.Logln("error parsing uri: ", server+uri, ":", err)
-		ch <- serverResponse{server, nil}
-		return
+		log.Error().Str("backend", server).Str("uri", uri).Err(err).Msg("error parsing uri")
+		return nil, err, false
 	}
 	req := http.Request{
 		URL:    u,
 		Header: make(http.Header),
 	}
 
-	Limiter.enter(server)
-	defer Limiter.leave(server)
-	resp, err := storageClient.Do(&req)
+	// Bound this attempt by Config.TimeoutMs -- without it, a backend that
+	// accepts the connection but never answers hangs the goroutine forever;
+	// only the aggregate multiGet select would ever give up on it, and the
+	// breaker would never see a timeout to trip on.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(Config.TimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	resp, err := storageClient.Do(req.WithContext(ctx))
 	if err != nil {
-		logger.Logln("singleGet: error querying ", server, "/", uri, ":", err)
-		ch <- serverResponse{server, nil}
-		return
+		log.Warn().Str("backend", server).Str("uri", uri).Err(err).Msg("error querying backend")
+		return nil, err, true
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == 404 {
 		// carbonsserver replies with Not Found if we request a
 		// metric that it doesn't have -- makes sense
-		ch <- serverResponse{server, nil}
-		return
+		return nil, nil, false
 	}
 
 	if resp.StatusCode != 200 {
-		logger.Logln("bad response code ", server, "/", uri, ":", resp.StatusCode)
-		ch <- serverResponse{server, nil}
-		return
+		log.Warn().Str("backend", server).Str("uri", uri).Int("status", resp.StatusCode).Msg("bad response code")
+		return nil, fmt.Errorf("bad response code %d", resp.StatusCode), resp.StatusCode >= 500
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
-		logger.Logln("error reading body: ", server, "/", uri, ":", err)
-		ch <- serverResponse{server, nil}
+		log.Warn().Str("backend", server).Str("uri", uri).Err(err).Msg("error reading body")
+		return nil, err, true
+	}
+
+	return body, nil, false
+}
+
+func singleGet(log zerolog.Logger, op, uri, server string, ch chan<- serverResponse) {
+
+	start := time.Now()
+	b := breakerFor(server)
+
+	if allowed, _ := b.allow(); !allowed {
+		log.Debug().Str("backend", server).Msg("circuit breaker open, skipping")
+		Metrics.CircuitOpen.Add(1)
+		ch <- serverResponse{server: server}
 		return
 	}
 
-	ch <- serverResponse{server, body}
+	recordBackendRequest(server, op)
+
+	var body []byte
+	var err error
+	var retryable bool
+	var timedOut bool
+
+	limiter := currentLimiter()
+	limiter.enter(server)
+	defer limiter.leave(server)
+
+	for attempt := 0; attempt <= Config.Retry.Max; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff(attempt - 1))
+		}
+
+		if isGRPCBackend(server) {
+			body, err, retryable = grpcGet(uri, server)
+		} else {
+			body, err, retryable = doSingleGet(log, uri, server)
+		}
+		timedOut = isTimeoutErr(err)
+
+		if err == nil || !retryable || attempt == Config.Retry.Max {
+			break
+		}
+
+		log.Debug().Str("backend", server).Str("uri", uri).Int("attempt", attempt).Err(err).Msg("retrying")
+	}
+
+	b.record(server, err == nil, timedOut)
+
+	elapsed := time.Since(start)
+
+	ev := log.Debug()
+	if err != nil {
+		ev = log.Warn().Err(err)
+
+		outcome := outcomeHTTPError
+		if timedOut {
+			outcome = outcomeTimeout
+		}
+		observeBackendOutcome(server, op, outcome, elapsed.Seconds())
+	}
+	ev.Str("backend", server).Str("uri", uri).
+		Dur("latency_ms", elapsed).
+		Int("bytes", len(body)).
+		Msg("singleGet")
+
+	ch <- serverResponse{server, body, elapsed}
 }
 
-func multiGet(servers []string, uri string) []serverResponse {
+func multiGet(log zerolog.Logger, op string, servers []string, uri string) []serverResponse {
 
-	logger.Debugln("querying servers=", servers, "uri=", uri)
+	log.Debug().Strs("backends", servers).Str("uri", uri).Msg("multiGet")
 
 	// buffered channel so the goroutines don't block on send
 	ch := make(chan serverResponse, len(servers))
 
 	for _, server := range servers {
-		go singleGet(uri, server, ch)
+		go singleGet(log, op, uri, server, ch)
 	}
 
 	var response []serverResponse
@@ -171,7 +332,7 @@ GATHER:
 			for _, r := range response {
 				servs = append(servs, r.server)
 			}
-			logger.Logln("Timeout waiting for more responses.  uri=", uri, ", servers=", servers, ", answers_from_servers=", servs)
+			log.Warn().Str("uri", uri).Strs("backends", servers).Strs("answered", servs).Msg("timeout waiting for more responses")
 			Metrics.Timeouts.Add(1)
 			break GATHER
 		}
@@ -180,7 +341,7 @@ GATHER:
 	return response
 }
 
-func findHandlerPB(w http.ResponseWriter, req *http.Request, responses []serverResponse) ([]*pb.GlobMatch, map[string][]string) {
+func findHandlerPB(log zerolog.Logger, w http.ResponseWriter, req *http.Request, responses []serverResponse) ([]*pb.GlobMatch, map[string][]string) {
 
 	// metric -> [server1, ... ]
 	paths := make(map[string][]string)
@@ -190,11 +351,13 @@ func findHandlerPB(w http.ResponseWriter, req *http.Request, responses []serverR
 		var metric pb.GlobResponse
 		err := proto.Unmarshal(r.response, &metric)
 		if err != nil {
-			logger.Logf("error decoding protobuf response from server:%s: req:%s: err=%s", r.server, req.URL.RequestURI(), err)
-			logger.Traceln("\n" + hex.Dump(r.response))
+			log.Error().Str("backend", r.server).Str("uri", req.URL.RequestURI()).Err(err).Msg("error decoding protobuf response")
+			log.Trace().Str("dump", hex.Dump(r.response)).Msg("response body")
 			Metrics.FindErrors.Add(1)
+			observeBackendOutcome(r.server, opFind, outcomeDecodeError, r.duration.Seconds())
 			continue
 		}
+		observeBackendOutcome(r.server, opFind, outcomeOK, r.duration.Seconds())
 
 		for _, match := range metric.Matches {
 			p, ok := paths[*match.Path]
@@ -214,7 +377,8 @@ func findHandlerPB(w http.ResponseWriter, req *http.Request, responses []serverR
 
 func findHandler(w http.ResponseWriter, req *http.Request) {
 
-	logger.Debugln("request: ", req.URL.RequestURI())
+	log := requestLogger(req, "find")
+	log.Debug().Str("uri", req.URL.RequestURI()).Msg("request")
 
 	Metrics.FindRequests.Add(1)
 
@@ -224,15 +388,16 @@ func findHandler(w http.ResponseWriter, req *http.Request) {
 	v.Set("format", "protobuf")
 	rewrite.RawQuery = v.Encode()
 
-	responses := multiGet(Config.Backends, rewrite.RequestURI())
+	query := req.FormValue("query")
+	responses := multiGet(log, opFind, backendsForMetric(query), rewrite.RequestURI())
 
 	if responses == nil || len(responses) == 0 {
-		logger.Logln("find: error querying backends for: ", rewrite.RequestURI())
+		log.Error().Str("uri", rewrite.RequestURI()).Msg("find: error querying backends")
 		http.Error(w, "find: error querying backends", http.StatusInternalServerError)
 		return
 	}
 
-	metrics, paths := findHandlerPB(w, req, responses)
+	metrics, paths := findHandlerPB(log, w, req, responses)
 
 	// update our cache of which servers have which metrics
 	Config.mu.Lock()
@@ -245,7 +410,6 @@ func findHandler(w http.ResponseWriter, req *http.Request) {
 	case "protobuf":
 		w.Header().Set("Content-Type", "application/protobuf")
 		var result pb.GlobResponse
-		query := req.FormValue("query")
 		result.Name = &query
 		result.Matches = metrics
 		b, _ := proto.Marshal(&result)
@@ -274,7 +438,8 @@ func findHandler(w http.ResponseWriter, req *http.Request) {
 
 func renderHandler(w http.ResponseWriter, req *http.Request) {
 
-	logger.Debugln("request: ", req.URL.RequestURI())
+	log := requestLogger(req, "render")
+	log.Debug().Str("uri", req.URL.RequestURI()).Msg("request")
 
 	Metrics.RenderRequests.Add(1)
 
@@ -286,32 +451,35 @@ func renderHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	var serverList []string
-	var ok bool
-
+	// lookup the server list for this metric, or fall back to the shard(s) it
+	// hashes to. backendsForMetric takes Config.mu itself, so it's called
+	// outside this lock rather than nested inside it -- RWMutex.RLock isn't
+	// safe to reacquire from the same goroutine while held, since a writer
+	// queued in between the two calls would deadlock both.
 	Config.mu.RLock()
-	// lookup the server list for this metric, or use all the servers if it's unknown
-	if serverList, ok = Config.metricPaths[target]; !ok || serverList == nil || len(serverList) == 0 {
-		serverList = Config.Backends
-	}
+	serverList, ok := Config.metricPaths[target]
 	Config.mu.RUnlock()
 
+	if !ok || serverList == nil || len(serverList) == 0 {
+		serverList = backendsForMetric(target)
+	}
+
 	format := req.FormValue("format")
 	rewrite, _ := url.ParseRequestURI(req.URL.RequestURI())
 	v := rewrite.Query()
 	v.Set("format", "protobuf")
 	rewrite.RawQuery = v.Encode()
 
-	responses := multiGet(serverList, rewrite.RequestURI())
+	responses := multiGet(log, opRender, serverList, rewrite.RequestURI())
 
 	if responses == nil || len(responses) == 0 {
-		logger.Logln("render: error querying backends for:", req.URL.RequestURI(), "backends:", serverList)
+		log.Error().Str("uri", req.URL.RequestURI()).Strs("backends", serverList).Msg("render: error querying backends")
 		http.Error(w, "render: error querying backends", http.StatusInternalServerError)
 		Metrics.RenderErrors.Add(1)
 		return
 	}
 
-	handleRenderPB(w, req, format, responses)
+	handleRenderPB(log, w, req, format, responses)
 }
 
 func createRenderResponse(metric pb.FetchResponse, missing interface{}) map[string]interface{} {
@@ -359,33 +527,33 @@ func returnRender(w http.ResponseWriter, format string, metric pb.FetchResponse)
 
 }
 
-func handleRenderPB(w http.ResponseWriter, req *http.Request, format string, responses []serverResponse) {
+func handleRenderPB(log zerolog.Logger, w http.ResponseWriter, req *http.Request, format string, responses []serverResponse) {
 
 	var decoded []pb.FetchResponse
 	for _, r := range responses {
 		var d pb.FetchResponse
 		err := proto.Unmarshal(r.response, &d)
 		if err != nil {
-			logger.Logf("error decoding protobuf response from server:%s: req:%s: err=%s", r.server, req.URL.RequestURI(), err)
-			logger.Traceln("\n" + hex.Dump(r.response))
+			log.Error().Str("backend", r.server).Str("uri", req.URL.RequestURI()).Err(err).Msg("error decoding protobuf response")
+			log.Trace().Str("dump", hex.Dump(r.response)).Msg("response body")
 			Metrics.RenderErrors.Add(1)
+			observeBackendOutcome(r.server, opRender, outcomeDecodeError, r.duration.Seconds())
 			continue
 		}
+		observeBackendOutcome(r.server, opRender, outcomeOK, r.duration.Seconds())
 		decoded = append(decoded, d)
 	}
 
-	logger.Traceln("request: %s: %v", req.URL.RequestURI(), decoded)
-
 	if len(decoded) == 0 {
 		err := fmt.Sprintf("no decoded responses to merge for req: %s", req.URL.RequestURI())
-		logger.Logln(err)
+		log.Error().Msg(err)
 		http.Error(w, err, http.StatusInternalServerError)
 		Metrics.RenderErrors.Add(1)
 		return
 	}
 
 	if len(decoded) == 1 {
-		logger.Debugf("only one decoded responses to merge for req: %s", req.URL.RequestURI())
+		log.Debug().Str("uri", req.URL.RequestURI()).Msg("only one decoded response, nothing to merge")
 		returnRender(w, format, decoded[0])
 		return
 	}
@@ -401,12 +569,12 @@ func handleRenderPB(w http.ResponseWriter, req *http.Request, format string, res
 
 	metric := decoded[0]
 
-	mergeValues(req, &metric, decoded)
+	mergeValues(log, req, &metric, decoded)
 
 	returnRender(w, format, metric)
 }
 
-func mergeValues(req *http.Request, metric *pb.FetchResponse, decoded []pb.FetchResponse) {
+func mergeValues(log zerolog.Logger, req *http.Request, metric *pb.FetchResponse, decoded []pb.FetchResponse) {
 
 	var responseLengthMismatch bool
 	for i := range metric.Values {
@@ -420,7 +588,10 @@ func mergeValues(req *http.Request, metric *pb.FetchResponse, decoded []pb.Fetch
 			m := decoded[other]
 
 			if len(m.Values) != len(metric.Values) {
-				logger.Logf("request: %s: unable to merge ovalues: len(values)=%d but len(ovalues)=%d", req.URL.RequestURI(), len(metric.Values), len(m.Values))
+				log.Warn().Str("uri", req.URL.RequestURI()).
+					Int("len_values", len(metric.Values)).
+					Int("len_ovalues", len(m.Values)).
+					Msg("unable to merge ovalues")
 				// TODO(dgryski): we should remove
 				// decoded[other] from the list of responses to
 				// consider but this assumes that decoded[0] is
@@ -429,6 +600,7 @@ func mergeValues(req *http.Request, metric *pb.FetchResponse, decoded []pb.Fetch
 				// we want to discard
 
 				Metrics.RenderErrors.Add(1)
+				responseMergeGaps.Inc()
 				responseLengthMismatch = true
 				break
 			}
@@ -444,7 +616,7 @@ func mergeValues(req *http.Request, metric *pb.FetchResponse, decoded []pb.Fetch
 
 func lbCheckHandler(w http.ResponseWriter, req *http.Request) {
 
-	logger.Traceln("loadbalancer: ", req.URL.RequestURI())
+	baseLogger.Trace().Str("uri", req.URL.RequestURI()).Msg("loadbalancer check")
 
 	fmt.Fprintf(w, "Ok\n")
 }
@@ -474,6 +646,7 @@ func main() {
 	debugLevel := flag.Int("d", 0, "enable debug logging")
 	logStdout := flag.Bool("stdout", false, "write logging output also to stdout")
 	logSyslog := flag.Bool("syslog", true, "write logging output also to syslog")
+	logPretty := flag.Bool("pretty", false, "colorize/pretty-print the stdout sink instead of emitting raw JSON (dev use only)")
 	concurrencyLimit := flag.Int("limit", 0, "concurrency limit per server (0 to disable)")
 
 	flag.Parse()
@@ -516,24 +689,24 @@ func main() {
 	}
 
 	// set up our logging
-	logger.level = logLevel(*debugLevel)
-	if *logSyslog {
-		slog, err := syslog.New(syslog.LOG_DAEMON, "carbonzipper")
-		if err != nil {
-			log.Fatal("can't obtain a syslog connection", err)
-		}
-		logger.loggers = append(logger.loggers, &sysLogger{w: slog})
+	level := zerolog.InfoLevel
+	switch {
+	case *debugLevel >= 2:
+		level = zerolog.TraceLevel
+	case *debugLevel == 1:
+		level = zerolog.DebugLevel
 	}
 
-	if *logStdout {
-		logger.loggers = append(logger.loggers, &stdoutLogger{log.New(os.Stdout, "", log.LstdFlags)})
+	if err := initLogging(*logStdout, *logSyslog, *logPretty, level); err != nil {
+		log.Fatal("can't set up logging: ", err)
 	}
 
-	logger.Logln("setting GOMAXPROCS=", Config.MaxProcs)
+	baseLogger.Info().Int("maxprocs", Config.MaxProcs).Msg("setting GOMAXPROCS")
 	runtime.GOMAXPROCS(Config.MaxProcs)
 
-	if *concurrencyLimit != 0 {
-		Limiter = newServerLimiter(Config.Backends, *concurrencyLimit)
+	configuredConcurrencyLimit = *concurrencyLimit
+	if configuredConcurrencyLimit != 0 {
+		setLimiter(newServerLimiter(Config.Backends, configuredConcurrencyLimit))
 	}
 
 	// +1 to track every over the number of buckets we track
@@ -544,10 +717,17 @@ func main() {
 
 	// export config via expvars
 	expvar.Publish("Config", expvar.Func(func() interface{} { return Config }))
+	expvar.Publish("CircuitBreakers", expvar.Func(breakerStates))
 
 	http.HandleFunc("/metrics/find/", httputil.TrackConnections(httputil.TimeHandler(findHandler, bucketRequestTimes)))
 	http.HandleFunc("/render/", httputil.TrackConnections(httputil.TimeHandler(renderHandler, bucketRequestTimes)))
 	http.HandleFunc("/lb_check", lbCheckHandler)
+	http.HandleFunc("/shards", shardsHandler)
+	http.HandleFunc("/debug/loglevel", logLevelHandler)
+	http.Handle("/metrics", metricsHandler())
+	http.HandleFunc("/config", configHandler)
+
+	startConfigReload(*configFile)
 
 	// nothing in the config? check the environment
 	if Config.GraphiteHost == "" {
@@ -559,7 +739,7 @@ func main() {
 	// only register g2g if we have a graphite host
 	if Config.GraphiteHost != "" {
 
-		logger.Logln("Using graphite host", Config.GraphiteHost)
+		baseLogger.Info().Str("graphite_host", Config.GraphiteHost).Msg("using graphite host")
 
 		// register our metrics with graphite
 		graphite, err := g2g.NewGraphite(Config.GraphiteHost, 60*time.Second, 10*time.Second)
@@ -589,7 +769,7 @@ func main() {
 	}
 
 	portStr := fmt.Sprintf(":%d", Config.Port)
-	logger.Logln("listening on", portStr)
+	baseLogger.Info().Str("addr", portStr).Msg("listening")
 	log.Fatal(http.ListenAndServe(portStr, nil))
 }
 
@@ -616,72 +796,7 @@ func bucketRequestTimes(req *http.Request, t time.Duration) {
 	} else {
 		// Too big? Increment overflow bucket and log
 		atomic.AddInt64(&timeBuckets[Config.Buckets], 1)
-		logger.Logf("Slow Request: %s: %s", t.String(), req.URL.String())
-	}
-}
-
-// trivial logging classes
-
-type logLevel int
-
-const (
-	LOG_NORMAL logLevel = iota
-	LOG_DEBUG
-	LOG_TRACE
-)
-
-// Logger is something that can log
-type Logger interface {
-	Log(string)
-}
-
-type stdoutLogger struct{ logger *log.Logger }
-
-func (l *stdoutLogger) Log(s string) { l.logger.Print(s) }
-
-type sysLogger struct{ w *syslog.Writer }
-
-func (l *sysLogger) Log(s string) { l.w.Info(s) }
-
-type multilog struct {
-	level   logLevel
-	loggers []Logger
-}
-
-func (ml *multilog) Debugf(format string, a ...interface{}) {
-	if ml.level >= LOG_DEBUG {
-		ml.Logf(format, a...)
-	}
-}
-
-func (ml *multilog) Debugln(a ...interface{}) {
-	if ml.level >= LOG_DEBUG {
-		ml.Logln(a...)
-	}
-}
-
-func (ml *multilog) Tracef(format string, a ...interface{}) {
-	if ml.level >= LOG_TRACE {
-		ml.Logf(format, a...)
-	}
-}
-
-func (ml *multilog) Traceln(a ...interface{}) {
-	if ml.level >= LOG_TRACE {
-		ml.Logln(a...)
-	}
-}
-func (ml *multilog) Logln(a ...interface{}) {
-	s := fmt.Sprintln(a...)
-	for _, l := range ml.loggers {
-		l.Log(s)
-	}
-}
-
-func (ml *multilog) Logf(format string, a ...interface{}) {
-	s := fmt.Sprintf(format, a...)
-	for _, l := range ml.loggers {
-		l.Log(s)
+		baseLogger.Warn().Str("uri", req.URL.String()).Dur("latency_ms", t).Msg("slow request")
 	}
 }
 
@@ -697,7 +812,12 @@ func newServerLimiter(servers []string, l int) serverLimiter {
 	return sl
 }
 
+// enter/leave track backendInflight regardless of whether a concurrency
+// limit is configured -- sl is only nil when -limit is 0 (the default), and
+// the gauge should still reflect real in-flight requests in that case
+// rather than reading zero forever.
 func (sl serverLimiter) enter(s string) {
+	backendInflight.WithLabelValues(s).Inc()
 	if sl == nil {
 		return
 	}
@@ -705,6 +825,7 @@ func (sl serverLimiter) enter(s string) {
 }
 
 func (sl serverLimiter) leave(s string) {
+	backendInflight.WithLabelValues(s).Dec()
 	if sl == nil {
 		return
 	}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// op labels distinguish find from render in the backend-level metrics below.
+const (
+	opFind   = "find"
+	opRender = "render"
+)
+
+// outcome labels for backendRequestDuration. decode_error is recorded
+// separately from the HTTP/gRPC transport outcomes because it's only known
+// once findHandlerPB/handleRenderPB has tried to unmarshal the payload.
+const (
+	outcomeOK          = "ok"
+	outcomeTimeout     = "timeout"
+	outcomeHTTPError   = "http_error"
+	outcomeDecodeError = "decode_error"
+)
+
+var (
+	backendRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "carbonzipper",
+		Name:      "backend_request_duration_seconds",
+		Help:      "Time spent on a single backend's half of a find/render fan-out.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "op", "outcome"})
+
+	backendInflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "carbonzipper",
+		Name:      "backend_inflight",
+		Help:      "Requests currently in flight to a backend, tracked whether or not -limit is set.",
+	}, []string{"backend"})
+
+	findRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "carbonzipper",
+		Name:      "find_requests_total",
+		Help:      "Find requests sent to a backend.",
+	}, []string{"backend"})
+
+	renderRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "carbonzipper",
+		Name:      "render_requests_total",
+		Help:      "Render requests sent to a backend.",
+	}, []string{"backend"})
+
+	backendTimeoutsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "carbonzipper",
+		Name:      "timeouts_total",
+		Help:      "Per-backend request timeouts.",
+	}, []string{"backend"})
+
+	responseMergeGaps = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "carbonzipper",
+		Name:      "response_merge_gaps",
+		Help:      "Times mergeValues hit a response it couldn't reconcile (responseLengthMismatch).",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		backendRequestDuration,
+		backendInflight,
+		findRequestsTotal,
+		renderRequestsTotal,
+		backendTimeoutsTotal,
+		responseMergeGaps,
+	)
+}
+
+// recordBackendRequest counts one request sent to server, before we know
+// how it'll turn out; retry attempts within that request are not counted
+// separately.
+func recordBackendRequest(server, op string) {
+	switch op {
+	case opFind:
+		findRequestsTotal.WithLabelValues(server).Inc()
+	case opRender:
+		renderRequestsTotal.WithLabelValues(server).Inc()
+	}
+}
+
+// observeBackendOutcome records the backend's half of the request -- its
+// latency and how it ended -- against the histogram.
+func observeBackendOutcome(server, op, outcome string, elapsed float64) {
+	backendRequestDuration.WithLabelValues(server, op, outcome).Observe(elapsed)
+	if outcome == outcomeTimeout {
+		backendTimeoutsTotal.WithLabelValues(server).Inc()
+	}
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"strings"
+)
+
+// ShardGroup is the set of backends that together serve one logical shard
+// of the metric keyspace.  Every backend in a group is expected to hold the
+// same metrics (it's the ReplicationFactor copies of that shard), so a
+// find/render for a metric that hashes into this shard can go to any (or
+// all, for redundancy) of them.
+type ShardGroup struct {
+	Shard    int      `json:"shard"`
+	Backends []string `json:"backends"`
+}
+
+// rendezvousShard picks the shard owning metric using highest-random-weight
+// (rendezvous) hashing over the shard ids.  Unlike `hash(metric) % N`, only
+// ~1/N of keys move when a shard is added or removed, because each metric's
+// ranking of the other shards doesn't change.
+func rendezvousShard(metric string, numShards int) int {
+	if numShards <= 1 {
+		return 0
+	}
+
+	best := 0
+	var bestScore uint64
+
+	for shard := 0; shard < numShards; shard++ {
+		h := fnv.New64a()
+		h.Write([]byte(metric))
+		// mix in the shard id so each shard gets an independent score
+		h.Write([]byte{byte(shard), byte(shard >> 8), byte(shard >> 16), byte(shard >> 24)})
+		score := h.Sum64()
+		if score > bestScore {
+			bestScore = score
+			best = shard
+		}
+	}
+
+	return best
+}
+
+// globMetaChars are the wildcard characters carbon's finder syntax
+// recognizes in a query (*, ?, [...], {...}). A query containing any of
+// them can match metrics scattered across every shard, so it can't be
+// pinned to a single shard by hashing the literal query string the way a
+// single resolved metric path can.
+const globMetaChars = "*?[{"
+
+func isGlobQuery(metric string) bool {
+	return strings.ContainsAny(metric, globMetaChars)
+}
+
+// shardCount is Config.Sharding.TotalMachines, the authoritative ring size
+// for rendezvousShard -- it's what an operator grows/shrinks to add or
+// remove shards, independent of how many groups this particular zipper
+// happens to have been told about. Falls back to len(Groups) if
+// TotalMachines wasn't set, so an old config with no opinion on ring size
+// still behaves the way it did before TotalMachines was wired up.
+func shardCount() int {
+	if Config.Sharding.TotalMachines > 0 {
+		return Config.Sharding.TotalMachines
+	}
+	return len(Config.Sharding.Groups)
+}
+
+// backendsForMetric returns the backends that own the shard `metric` hashes
+// into, i.e. the ReplicationFactor replicas to fan the query out to. When
+// sharding isn't configured, or metric is a glob that could match metrics on
+// any shard, it falls back to querying every backend -- for an unsharded
+// deployment that's the whole fleet anyway, and for a sharded one every
+// backend belongs to exactly one shard group, so querying all of them is
+// equivalent to fanning out to every group.
+func backendsForMetric(metric string) []string {
+	if len(Config.Sharding.Groups) == 0 || isGlobQuery(metric) {
+		Config.mu.RLock()
+		defer Config.mu.RUnlock()
+		return Config.Backends
+	}
+
+	shard := rendezvousShard(metric, shardCount())
+	for _, g := range Config.Sharding.Groups {
+		if g.Shard == shard {
+			return g.Backends
+		}
+	}
+
+	// no group claims this shard id -- fall back rather than querying nobody
+	Config.mu.RLock()
+	defer Config.mu.RUnlock()
+	return Config.Backends
+}
+
+// shardsHandler dumps the current shard ring so operators can verify that a
+// metric lands where they expect before chasing it through logs.
+func shardsHandler(w http.ResponseWriter, req *http.Request) {
+	metric := req.FormValue("metric")
+
+	resp := struct {
+		TotalMachines     int          `json:"totalMachines"`
+		ReplicationFactor int          `json:"replicationFactor"`
+		Groups            []ShardGroup `json:"groups"`
+		Lookup            string       `json:"lookup,omitempty"`
+		Shard             int          `json:"shard,omitempty"`
+		Backends          []string     `json:"backends,omitempty"`
+	}{
+		TotalMachines:     Config.Sharding.TotalMachines,
+		ReplicationFactor: Config.Sharding.ReplicationFactor,
+		Groups:            Config.Sharding.Groups,
+	}
+
+	if metric != "" {
+		resp.Lookup = metric
+		resp.Shard = rendezvousShard(metric, shardCount())
+		resp.Backends = backendsForMetric(metric)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	jEnc := json.NewEncoder(w)
+	jEnc.Encode(resp)
+}